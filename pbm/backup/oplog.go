@@ -0,0 +1,317 @@
+package backup
+
+import (
+	"context"
+	"io"
+	"path"
+
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/percona/percona-backup-mongodb/pbm"
+)
+
+// Oplog tails a single replset's oplog between two timestamps, exposing it as an io.Reader of raw
+// BSON documents for Upload to stream to storage. It also answers the questions the PITR streamer
+// (pbm/pitr) needs to schedule and checkpoint slices: how far the source oplog has grown
+// (LastWrite) and which namespaces it actually emitted into the current span (MatchedNamespaces,
+// once a namespace filter is set via SetFilter).
+type Oplog struct {
+	node *pbm.Node
+
+	from primitive.Timestamp
+	to   primitive.Timestamp
+
+	include []string
+	exclude []string
+
+	cur      *mongo.Cursor
+	matched  map[string]struct{}
+	lastRead primitive.Timestamp
+
+	// queue holds entries already pulled off cur and run through the namespace filter, waiting to
+	// become pending; filtering an applyOps entry can turn one cursor advance into several queued
+	// documents (or zero, if nothing in it survives).
+	queue []bson.Raw
+	// pending is the still-unwritten tail of the document at the front of the queue, drained
+	// across as many Read calls as it takes since a single document routinely doesn't fit in the
+	// caller's buffer.
+	pending bson.Raw
+}
+
+// NewOplog creates an Oplog tailer bound to node. SetTailingSpan must be called before Read.
+func NewOplog(node *pbm.Node) *Oplog {
+	return &Oplog{node: node, matched: make(map[string]struct{})}
+}
+
+// SetTailingSpan bounds the next Read to oplog entries with ts in [from, to].
+func (o *Oplog) SetTailingSpan(from, to primitive.Timestamp) {
+	o.from = from
+	o.to = to
+	o.cur = nil
+	o.matched = make(map[string]struct{})
+	o.lastRead = from
+	o.queue = nil
+	o.pending = nil
+}
+
+// SetFilter restricts Read to entries touching a namespace matching include (or all namespaces, if
+// include is empty) and not matching exclude; see pitr.NSFilter for the pattern syntax. Only an
+// entry whose o carries an applyOps array is filtered sub-op by sub-op; every other entry,
+// including DDL commands and commitTransaction, is kept or dropped whole by its own top-level ns
+// (db.$cmd, admin.$cmd).
+func (o *Oplog) SetFilter(include, exclude []string) {
+	o.include = include
+	o.exclude = exclude
+}
+
+// MatchedNamespaces returns the distinct namespaces actually emitted by the tailing pass since the
+// last SetTailingSpan (including those of applyOps sub-ops), for the sidecar manifest written
+// alongside a filtered chunk.
+func (o *Oplog) MatchedNamespaces() []string {
+	ns := make([]string, 0, len(o.matched))
+	for n := range o.matched {
+		ns = append(ns, n)
+	}
+
+	return ns
+}
+
+// LastWrite returns the timestamp of the most recent entry anywhere in the oplog, regardless of
+// how far this tailer has read - the oplog's global tip, used to decide when the gap between now
+// and the in-flight slice justifies a cut.
+func (o *Oplog) LastWrite() (primitive.Timestamp, error) {
+	res := o.node.Session().Database("local").Collection("oplog.rs").FindOne(
+		context.Background(),
+		bson.D{},
+		options.FindOne().SetSort(bson.D{{"$natural", -1}}),
+	)
+
+	doc := struct {
+		TS primitive.Timestamp `bson:"ts"`
+	}{}
+	if err := res.Decode(&doc); err != nil {
+		return primitive.Timestamp{}, errors.Wrap(err, "get last oplog entry")
+	}
+
+	return doc.TS, nil
+}
+
+// LastRead returns the timestamp of the most recent entry this tailer has actually emitted via
+// Read - its own progress through the current span - as opposed to LastWrite, which reflects the
+// oplog's global tip regardless of how much of it has been read yet. Checkpointing LastRead rather
+// than LastWrite is what lets a resumed tailer pick up exactly where it left off instead of
+// skipping entries that were never actually persisted.
+func (o *Oplog) LastRead() primitive.Timestamp {
+	return o.lastRead
+}
+
+// EstimateSize projects the on-disk size of the oplog between from and to without reading it, by
+// scaling the oplog's average entry size (from collStats) by the number of entries in that span.
+// Used by the adaptive scheduler to decide whether the in-flight slice is on track to hit
+// MaxChunkBytes before RPO would force a cut anyway.
+func (o *Oplog) EstimateSize(from, to primitive.Timestamp) (int64, error) {
+	coll := o.node.Session().Database("local").Collection("oplog.rs")
+
+	n, err := coll.CountDocuments(context.Background(), bson.D{
+		{"ts", bson.D{{"$gte", from}, {"$lte", to}}},
+	})
+	if err != nil {
+		return 0, errors.Wrap(err, "count entries in span")
+	}
+
+	res := coll.Database().RunCommand(context.Background(), bson.D{{"collStats", "oplog.rs"}})
+	stats := struct {
+		AvgObjSize float64 `bson:"avgObjSize"`
+	}{}
+	if err := res.Decode(&stats); err != nil {
+		return 0, errors.Wrap(err, "get oplog avg entry size")
+	}
+
+	return int64(float64(n) * stats.AvgObjSize), nil
+}
+
+// Read tails [o.from, o.to] off local.oplog.rs, applying the namespace filter set by SetFilter (if
+// any) and recording matched namespaces for MatchedNamespaces, writing raw BSON documents to p.
+//
+// A single oplog entry is routinely bigger than the buffer Upload reads with, so Read stashes
+// whatever doesn't fit into o.pending and drains it across as many calls as it takes before
+// advancing the cursor again - callers must not assume one Read returns one whole document.
+func (o *Oplog) Read(p []byte) (int, error) {
+	if len(o.pending) == 0 {
+		if err := o.advance(); err != nil {
+			return 0, err
+		}
+	}
+
+	n := copy(p, o.pending)
+	o.pending = o.pending[n:]
+
+	return n, nil
+}
+
+// advance fills o.pending with the next document to emit, filling the queue from the cursor as
+// many times as it takes to get one - an entry the filter drops entirely (or an applyOps entry
+// with no surviving sub-ops) advances the cursor without producing output.
+func (o *Oplog) advance() error {
+	for len(o.queue) == 0 {
+		if err := o.fillQueue(); err != nil {
+			return err
+		}
+	}
+
+	doc := o.queue[0]
+	o.queue = o.queue[1:]
+
+	if ns, ok := doc.Lookup("ns").StringValueOK(); ok && ns != "" {
+		o.matched[ns] = struct{}{}
+	}
+	if cmd, ok := doc.Lookup("o").DocumentOK(); ok {
+		if ops, ok := cmd.Lookup("applyOps").ArrayOK(); ok {
+			values, _ := ops.Values()
+			for _, v := range values {
+				sub, ok := v.DocumentOK()
+				if !ok {
+					continue
+				}
+				if ns, ok := sub.Lookup("ns").StringValueOK(); ok && ns != "" {
+					o.matched[ns] = struct{}{}
+				}
+			}
+		}
+	}
+	if t, i, ok := doc.Lookup("ts").TimestampOK(); ok {
+		o.lastRead = primitive.Timestamp{T: t, I: i}
+	}
+
+	o.pending = doc
+
+	return nil
+}
+
+// fillQueue reads the next raw entry off the cursor - opening it on first use - and appends
+// whatever filterDoc says should be emitted for it (none, one, or several for a split applyOps
+// entry) to o.queue.
+func (o *Oplog) fillQueue() error {
+	if o.cur == nil {
+		cur, err := o.node.Session().Database("local").Collection("oplog.rs").Find(
+			context.Background(),
+			bson.D{{"ts", bson.D{{"$gte", o.from}, {"$lte", o.to}}}},
+		)
+		if err != nil {
+			return errors.Wrap(err, "open oplog cursor")
+		}
+		o.cur = cur
+	}
+
+	if !o.cur.Next(context.Background()) {
+		if err := o.cur.Err(); err != nil {
+			return errors.Wrap(err, "iterate oplog cursor")
+		}
+		return io.EOF
+	}
+
+	// The cursor reuses its buffer on the next Next() call, and a filtered/split entry can sit in
+	// o.queue across several of those, so it has to be copied off here.
+	doc := append(bson.Raw(nil), o.cur.Current...)
+
+	if o.include == nil && o.exclude == nil {
+		o.queue = append(o.queue, doc)
+		return nil
+	}
+
+	o.queue = append(o.queue, o.filterDoc(doc)...)
+
+	return nil
+}
+
+// filterDoc applies the namespace include/exclude patterns to a single oplog entry, returning the
+// documents that should be emitted in its place: none if the entry is dropped entirely, one for
+// the ordinary single-namespace case, or, for an entry whose o carries an applyOps array, a
+// rewritten copy in which each sub-op was matched against its own namespace rather than the
+// entry's own top-level ns (always admin.$cmd). Any other entry is matched on its top-level ns.
+func (o *Oplog) filterDoc(doc bson.Raw) []bson.Raw {
+	if cmd, ok := doc.Lookup("o").DocumentOK(); ok {
+		if ops, ok := cmd.Lookup("applyOps").ArrayOK(); ok {
+			return o.filterApplyOps(doc, ops)
+		}
+	}
+
+	ns, _ := doc.Lookup("ns").StringValueOK()
+	if o.nsAllowed(ns) {
+		return []bson.Raw{doc}
+	}
+
+	return nil
+}
+
+// filterApplyOps keeps only the sub-ops of an applyOps entry whose own ns passes
+// the filter, rewriting doc's o.applyOps array to hold just those. If nothing survives, the whole
+// entry is dropped rather than emitted with an empty applyOps array.
+func (o *Oplog) filterApplyOps(doc bson.Raw, ops bson.Raw) []bson.Raw {
+	values, err := ops.Values()
+	if err != nil {
+		return nil
+	}
+
+	var kept bson.A
+	for _, v := range values {
+		sub, ok := v.DocumentOK()
+		if !ok {
+			continue
+		}
+		ns, _ := sub.Lookup("ns").StringValueOK()
+		if o.nsAllowed(ns) {
+			kept = append(kept, sub)
+		}
+	}
+	if len(kept) == 0 {
+		return nil
+	}
+
+	var top bson.D
+	if err := bson.Unmarshal(doc, &top); err != nil {
+		return nil
+	}
+	for i, e := range top {
+		inner, ok := e.Value.(bson.D)
+		if e.Key != "o" || !ok {
+			continue
+		}
+		for j, ie := range inner {
+			if ie.Key == "applyOps" {
+				inner[j].Value = kept
+			}
+		}
+		top[i].Value = inner
+	}
+
+	b, err := bson.Marshal(top)
+	if err != nil {
+		return nil
+	}
+
+	return []bson.Raw{bson.Raw(b)}
+}
+
+// nsAllowed reports whether ns passes the include/exclude patterns.
+func (o *Oplog) nsAllowed(ns string) bool {
+	for _, p := range o.exclude {
+		if ok, _ := path.Match(p, ns); ok {
+			return false
+		}
+	}
+	if len(o.include) == 0 {
+		return true
+	}
+	for _, p := range o.include {
+		if ok, _ := path.Match(p, ns); ok {
+			return true
+		}
+	}
+
+	return false
+}
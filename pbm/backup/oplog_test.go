@@ -0,0 +1,111 @@
+package backup
+
+import (
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestOplogFilterDocSingleNamespace(t *testing.T) {
+	o := &Oplog{include: []string{"db1.*"}}
+
+	doc, err := bson.Marshal(bson.D{{"ns", "db1.coll"}, {"op", "i"}})
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+	if got := o.filterDoc(doc); len(got) != 1 {
+		t.Fatalf("got %d docs, want 1 for a matching namespace", len(got))
+	}
+
+	doc, err = bson.Marshal(bson.D{{"ns", "db2.coll"}, {"op", "i"}})
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+	if got := o.filterDoc(doc); len(got) != 0 {
+		t.Fatalf("got %d docs, want 0 for a non-matching namespace", len(got))
+	}
+}
+
+func TestOplogFilterDocSplitsApplyOps(t *testing.T) {
+	o := &Oplog{include: []string{"db1.*"}}
+
+	doc, err := bson.Marshal(bson.D{
+		{"ns", "admin.$cmd"},
+		{"op", "c"},
+		{"o", bson.D{
+			{"applyOps", bson.A{
+				bson.D{{"ns", "db1.coll"}, {"op", "i"}, {"o", bson.D{{"_id", 1}}}},
+				bson.D{{"ns", "db2.coll"}, {"op", "i"}, {"o", bson.D{{"_id", 2}}}},
+			}},
+		}},
+	})
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+
+	got := o.filterDoc(doc)
+	if len(got) != 1 {
+		t.Fatalf("got %d docs, want 1 (the rewritten applyOps entry)", len(got))
+	}
+
+	var out struct {
+		O struct {
+			ApplyOps []bson.D `bson:"applyOps"`
+		} `bson:"o"`
+	}
+	if err := bson.Unmarshal(got[0], &out); err != nil {
+		t.Fatalf("unmarshal rewritten entry: %v", err)
+	}
+	if len(out.O.ApplyOps) != 1 {
+		t.Fatalf("got %d surviving sub-ops, want 1 (only the db1.coll one)", len(out.O.ApplyOps))
+	}
+}
+
+func TestOplogFilterDocDropsApplyOpsWhenNoSubOpMatches(t *testing.T) {
+	o := &Oplog{include: []string{"db1.*"}}
+
+	doc, err := bson.Marshal(bson.D{
+		{"ns", "admin.$cmd"},
+		{"op", "c"},
+		{"o", bson.D{
+			{"applyOps", bson.A{
+				bson.D{{"ns", "db2.coll"}, {"op", "i"}, {"o", bson.D{{"_id", 1}}}},
+			}},
+		}},
+	})
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+
+	if got := o.filterDoc(doc); len(got) != 0 {
+		t.Fatalf("got %d docs, want 0 - no sub-op matched the filter", len(got))
+	}
+}
+
+func TestOplogReadDrainsAPendingDocumentAcrossCalls(t *testing.T) {
+	o := &Oplog{matched: make(map[string]struct{})}
+
+	doc, err := bson.Marshal(bson.D{{"ns", "db1.coll"}, {"op", "i"}})
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+	o.queue = []bson.Raw{doc}
+
+	buf := make([]byte, 4)
+	var got []byte
+	for {
+		n, err := o.Read(buf)
+		got = append(got, buf[:n]...)
+		if err != nil {
+			t.Fatalf("Read: %v", err)
+		}
+		if len(o.pending) == 0 {
+			break
+		}
+	}
+
+	if len(got) != len(doc) {
+		t.Fatalf("got %d bytes across calls, want %d - Read must not drop the tail of a document "+
+			"larger than the caller's buffer", len(got), len(doc))
+	}
+}
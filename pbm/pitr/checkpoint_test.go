@@ -0,0 +1,61 @@
+package pitr
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/percona/percona-backup-mongodb/pbm"
+)
+
+func TestCheckpointJSONRoundTrip(t *testing.T) {
+	cp := checkpoint{
+		LastTS:           primitive.Timestamp{T: 100, I: 1},
+		InflightFName:    "pbmPitr/rs0/20260101/20260101000000.20260101000100.oplog.gz",
+		InflightStartTS:  primitive.Timestamp{T: 90, I: 0},
+		PlannedEndTS:     primitive.Timestamp{T: 100, I: 1},
+		Compression:      pbm.CompressionType("gzip"),
+		CipherAlgorithm:  CipherAES256GCM,
+		CipherKDF:        "static",
+		CipherWrappedKey: []byte{1, 2, 3, 4},
+		Filter:           "abc123def456",
+	}
+
+	b, err := json.Marshal(cp)
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+
+	var got checkpoint
+	if err := json.Unmarshal(b, &got); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+
+	if !reflect.DeepEqual(cp, got) {
+		t.Fatalf("round-tripped checkpoint differs from the original:\n got:  %+v\n want: %+v", got, cp)
+	}
+}
+
+func TestCheckpointJSONRoundTripUnencryptedUnfiltered(t *testing.T) {
+	cp := checkpoint{
+		LastTS:        primitive.Timestamp{T: 50, I: 0},
+		InflightFName: "pbmPitr/rs0/20260101/20260101000000.20260101000100.oplog",
+		PlannedEndTS:  primitive.Timestamp{T: 60, I: 0},
+	}
+
+	b, err := json.Marshal(cp)
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+
+	var got checkpoint
+	if err := json.Unmarshal(b, &got); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+
+	if !reflect.DeepEqual(cp, got) {
+		t.Fatalf("round-tripped checkpoint differs from the original:\n got:  %+v\n want: %+v", got, cp)
+	}
+}
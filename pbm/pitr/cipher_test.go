@@ -0,0 +1,94 @@
+package pitr
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/binary"
+	"io/ioutil"
+	"testing"
+)
+
+func TestNewCipherReaderRejectsNonAES256Key(t *testing.T) {
+	for _, n := range []int{0, 16, 24, 31, 33} {
+		ci := &CipherInfo{Algorithm: CipherAES256GCM, Key: make([]byte, n)}
+		if _, err := newCipherReader(bytes.NewReader(nil), ci); err == nil {
+			t.Errorf("newCipherReader with a %d-byte key: expected an error, got none", n)
+		}
+	}
+}
+
+func TestCipherReaderFrameRoundTrip(t *testing.T) {
+	plain := bytes.Repeat([]byte("the quick brown fox jumps over the lazy dog"), 100)
+
+	key := make([]byte, 32)
+	for j := range key {
+		key[j] = byte(j)
+	}
+	ci := &CipherInfo{Algorithm: CipherAES256GCM, Key: key}
+
+	cr, err := newCipherReader(bytes.NewReader(plain), ci)
+	if err != nil {
+		t.Fatalf("newCipherReader: %v", err)
+	}
+
+	sealed, err := ioutil.ReadAll(cr)
+	if err != nil {
+		t.Fatalf("read ciphertext: %v", err)
+	}
+	if bytes.Contains(sealed, plain) {
+		t.Fatal("sealed output contains the plaintext verbatim")
+	}
+
+	got, err := decryptFrames(t, sealed, key)
+	if err != nil {
+		t.Fatalf("decrypt: %v", err)
+	}
+	if !bytes.Equal(got, plain) {
+		t.Fatal("round-tripped plaintext doesn't match the original")
+	}
+}
+
+// decryptFrames reimplements the restore-side counterpart of cipherReader's framing - nonce
+// derivation included - just enough to assert the writer side round-trips correctly.
+func decryptFrames(t *testing.T, sealed, key []byte) ([]byte, error) {
+	t.Helper()
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := sealed[:encNonceSize]
+	rest := sealed[encNonceSize:]
+
+	var out bytes.Buffer
+	var seq uint64
+	for len(rest) > 0 {
+		frameLen := binary.BigEndian.Uint32(rest[:4])
+		rest = rest[4:]
+		frame := rest[:frameLen]
+		rest = rest[frameLen:]
+
+		fn := make([]byte, encNonceSize)
+		copy(fn, nonce)
+		var seqBuf [8]byte
+		binary.BigEndian.PutUint64(seqBuf[:], seq)
+		for j, b := range seqBuf {
+			fn[encNonceSize-8+j] ^= b
+		}
+		seq++
+
+		plain, err := aead.Open(nil, fn, frame, nil)
+		if err != nil {
+			return nil, err
+		}
+		out.Write(plain)
+	}
+
+	return out.Bytes(), nil
+}
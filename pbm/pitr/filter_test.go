@@ -0,0 +1,28 @@
+package pitr
+
+import "testing"
+
+func TestNSFilterFingerprintEmpty(t *testing.T) {
+	f := NSFilter{}
+	if got := f.Fingerprint(); got != "" {
+		t.Errorf("Fingerprint of an empty filter = %q, want empty string", got)
+	}
+}
+
+func TestNSFilterFingerprintStableUnderReordering(t *testing.T) {
+	a := NSFilter{Include: []string{"db1.*", "db2.*"}, Exclude: []string{"*.system.*"}}
+	b := NSFilter{Include: []string{"db2.*", "db1.*"}, Exclude: []string{"*.system.*"}}
+
+	if a.Fingerprint() != b.Fingerprint() {
+		t.Error("Fingerprint depends on pattern order, it shouldn't")
+	}
+}
+
+func TestNSFilterFingerprintDiffersOnContent(t *testing.T) {
+	a := NSFilter{Include: []string{"db1.*"}}
+	b := NSFilter{Include: []string{"db2.*"}}
+
+	if a.Fingerprint() == b.Fingerprint() {
+		t.Error("distinct filters produced the same Fingerprint")
+	}
+}
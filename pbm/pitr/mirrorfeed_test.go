@@ -0,0 +1,53 @@
+package pitr
+
+import (
+	"io"
+	"io/ioutil"
+	"testing"
+	"time"
+)
+
+func TestMirrorFeedDropsWhenConsumerStalls(t *testing.T) {
+	pr, pw := io.Pipe()
+	f := newMirrorFeed(pw)
+	defer pr.Close()
+
+	// Never read from pr - simulate a mirror upload that's stuck. Writing well past the queue
+	// depth must still return promptly instead of blocking on the stalled consumer.
+	done := make(chan struct{})
+	go func() {
+		for j := 0; j < mirrorQueueDepth*2; j++ {
+			f.write([]byte("x"))
+		}
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("write blocked on a stalled mirror consumer instead of dropping it")
+	}
+
+	if _, err := pr.Read(make([]byte, 1)); err == nil {
+		t.Fatal("expected the mirror's pipe to have been closed with an error once it fell behind")
+	}
+}
+
+func TestMirrorFeedDeliversWhenConsumerKeepsUp(t *testing.T) {
+	pr, pw := io.Pipe()
+	f := newMirrorFeed(pw)
+
+	go func() {
+		f.write([]byte("hello "))
+		f.write([]byte("mirror"))
+		f.done()
+	}()
+
+	got, err := ioutil.ReadAll(pr)
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if string(got) != "hello mirror" {
+		t.Fatalf("got %q, want %q", got, "hello mirror")
+	}
+}
@@ -1,10 +1,29 @@
 package pitr
 
 import (
+	"bytes"
+	"compress/gzip"
 	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"path"
+	"sort"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/golang/snappy"
+	"github.com/klauspost/compress/s2"
+	"github.com/klauspost/pgzip"
+	"github.com/pierrec/lz4"
 	"github.com/pkg/errors"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
@@ -15,33 +34,264 @@ import (
 )
 
 type IBackup struct {
-	pbm    *pbm.PBM
-	node   *pbm.Node
-	rs     string
-	span   time.Duration
-	lastTS primitive.Timestamp
-	log    *pbm.Logger
+	pbm      *pbm.PBM
+	node     *pbm.Node
+	rs       string
+	schedule Schedule
+	lastTS   primitive.Timestamp
+	log      *pbm.Logger
+	cipher   *CipherInfo
+	filter   *NSFilter
 }
 
 const (
 	fsPrefix    = "pbmPitr"
 	defaultSpan = time.Minute * 10
+
+	checkpointFile = "_checkpoint.json"
+	// checkpointFlushBytes is how often (in tailed oplog bytes) we persist
+	// the current cursor position while a slice is in flight. Keeps a crash
+	// from losing more than one flush interval's worth of work.
+	checkpointFlushBytes = 32 * 1024 * 1024
+
+	// encFrameSize is the amount of plaintext sealed into a single AEAD frame. Framing the
+	// stream (instead of encrypting the whole slice as one AEAD message) lets each chunk be
+	// encrypted and, on restore, decrypted incrementally without buffering it in memory.
+	encFrameSize = 1 << 20 // 1MB
+	encNonceSize = 12
+
+	// schedulePollInterval is how often the adaptive scheduler samples oplog throughput while
+	// waiting to cut the next slice. It's independent of (and much finer-grained than) the
+	// slice cadence itself, which is bounded by Schedule.MinSpan/MaxSpan.
+	schedulePollInterval = time.Second * 5
+	// ewmaAlpha weights the adaptive scheduler's exponentially-weighted moving average of the
+	// oplog write rate - low enough that a short burst doesn't immediately swing the estimate.
+	ewmaAlpha = 0.3
+)
+
+// Schedule configures the adaptive cadence Stream uses to decide when to cut the next slice, in
+// place of the old fixed tick. A slice is cut as soon as any of: the gap between now and the
+// slice's start would exceed RPO, the slice has grown past MaxChunkBytes, or an EWMA of the
+// current write rate projects it won't reach MaxChunkBytes before RPO would force a cut anyway
+// (so a slice written too slowly to fill MaxChunkBytes within RPO is cut early, once MinSpan has
+// passed, instead of waiting out the deadline). MinSpan/MaxSpan clamp how often a cut can happen regardless of
+// the above, so a bursty or idle cluster doesn't produce pathologically tiny or stale slices.
+// Fields correspond to the PBM config keys pitr.rpo, pitr.maxChunkSizeMB, pitr.minSpan and
+// pitr.maxSpan.
+type Schedule struct {
+	RPO           time.Duration
+	MaxChunkBytes int64
+	MinSpan       time.Duration
+	MaxSpan       time.Duration
+}
+
+var defaultSchedule = Schedule{
+	RPO:           time.Minute * 10,
+	MaxChunkBytes: 500 * 1024 * 1024,
+	MinSpan:       time.Second * 10,
+	MaxSpan:       time.Minute * 10,
+}
+
+// sliceMetrics are the Prometheus-style counters operators use to tune Schedule: pitr_slice_bytes
+// (total oplog bytes committed), pitr_slice_duration_seconds (total time spent uploading slices)
+// and pitr_lag_seconds (gap between now and the in-flight slice's start, as of the last scheduling
+// decision). They're process-wide since a node runs at most one PITR streamer per replset at a
+// time.
+type sliceMetrics struct {
+	sliceBytes      int64
+	sliceDurationNs int64
+	lagSeconds      int64
+}
+
+var metrics sliceMetrics
+
+func (m *sliceMetrics) addSlice(bytes int64, dur time.Duration) {
+	atomic.AddInt64(&m.sliceBytes, bytes)
+	atomic.AddInt64(&m.sliceDurationNs, int64(dur))
+}
+
+func (m *sliceMetrics) setLag(d time.Duration) {
+	atomic.StoreInt64(&m.lagSeconds, int64(d/time.Second))
+}
+
+// Metrics returns a snapshot of the process-wide PITR slicing counters: pitr_slice_bytes,
+// pitr_slice_duration_seconds and pitr_lag_seconds.
+func Metrics() (sliceBytes int64, sliceDurationSeconds float64, lagSeconds int64) {
+	return atomic.LoadInt64(&metrics.sliceBytes),
+		time.Duration(atomic.LoadInt64(&metrics.sliceDurationNs)).Seconds(),
+		atomic.LoadInt64(&metrics.lagSeconds)
+}
+
+// CipherAlgorithm identifies the AEAD used to encrypt PITR oplog chunks. Only AES-256-GCM is
+// supported today; the field exists so restore can reject a chunk it doesn't know how to open
+// instead of guessing.
+type CipherAlgorithm string
+
+const (
+	CipherNone      CipherAlgorithm = ""
+	CipherAES256GCM CipherAlgorithm = "AES256-GCM"
 )
 
+// CipherInfo carries what Stream needs to encrypt oplog chunks client-side before they're
+// handed to storage.Storage. Key is the raw 32-byte data-encryption key used for the AEAD; how
+// it was obtained (unwrapped from an AWS KMS data key, a static key from PBM config, or derived
+// from an operator passphrase via PBKDF2) is the caller's concern, identified here only by KDF
+// so it can be recorded in PITRChunk meta. WrappedKey is the opaque, KDF-specific form of Key
+// that's safe to persist - it's what restore uses to get Key back.
+type CipherInfo struct {
+	Algorithm  CipherAlgorithm
+	KDF        string
+	Key        []byte
+	WrappedKey []byte
+}
+
+// NSFilter selects which oplog namespaces (db.collection) Stream writes into a chunk. Include
+// and Exclude entries are shell-style globs ("mydb.*", "*.system.*"); an op is kept when it
+// matches Include (or Include is empty, meaning everything) and doesn't match Exclude.
+// An entry carrying an applyOps array is filtered sub-op by sub-op; every other entry, including
+// DDL commands and commitTransaction, is kept or dropped whole by its own top-level ns (db.$cmd,
+// admin.$cmd), not by the collection it acts on.
+type NSFilter struct {
+	Include []string
+	Exclude []string
+}
+
+// Fingerprint identifies a filter's effective configuration. It's recorded on PITRChunk.Filter
+// so a restore - or a streamer resuming after a restart - can detect that the filter changed
+// somewhere along the timeline instead of silently mixing differently-filtered chunks.
+func (f NSFilter) Fingerprint() string {
+	if len(f.Include) == 0 && len(f.Exclude) == 0 {
+		return ""
+	}
+
+	inc := append([]string(nil), f.Include...)
+	exc := append([]string(nil), f.Exclude...)
+	sort.Strings(inc)
+	sort.Strings(exc)
+
+	h := sha256.New()
+	for _, p := range inc {
+		io.WriteString(h, "+"+p)
+	}
+	for _, p := range exc {
+		io.WriteString(h, "-"+p)
+	}
+
+	return hex.EncodeToString(h.Sum(nil))[:16]
+}
+
+// Target is one destination Stream writes a slice to. The first Target in the list passed to
+// Stream/Catchup is the primary: it must be Required, and it's the source of truth for the
+// checkpoint, chunk meta and manifest, all of which live in its storage only. Any Target after it
+// is a mirror - typically a second region bucket or a local filesystem cache - written
+// concurrently with the primary. A failed upload to a Required target aborts the slice, same as a
+// failure would have before there was more than one target; a failure on a non-Required (i.e.
+// best-effort) target is logged and left for the background mirror reconciler to back-fill,
+// keyed off the chunk meta the primary already committed.
+type Target struct {
+	Storage  storage.Storage
+	Required bool
+}
+
+func primaryTarget(targets []Target) (storage.Storage, error) {
+	if len(targets) == 0 {
+		return nil, errors.New("no targets defined")
+	}
+	if !targets[0].Required {
+		return nil, errors.New("the first target is the primary and must be required")
+	}
+
+	return targets[0].Storage, nil
+}
+
+// checkpoint is a small resume marker kept next to the chunks in the same
+// storage bucket. On (re)start Catchup reconciles it against PITRChunk
+// meta so a worker that died mid-slice can either finish the upload it
+// already has in storage or re-stream from the last confirmed point,
+// instead of leaving a gap or duplicating data.
+type checkpoint struct {
+	LastTS           primitive.Timestamp `json:"lastTS"`
+	InflightFName    string              `json:"inflightFName,omitempty"`
+	InflightStartTS  primitive.Timestamp `json:"inflightStartTS,omitempty"`
+	PlannedEndTS     primitive.Timestamp `json:"plannedEndTS,omitempty"`
+	Compression      pbm.CompressionType `json:"compression,omitempty"`
+	CipherAlgorithm  CipherAlgorithm     `json:"cipherAlgorithm,omitempty"`
+	CipherKDF        string              `json:"cipherKDF,omitempty"`
+	CipherWrappedKey []byte              `json:"cipherWrappedKey,omitempty"`
+	Filter           string              `json:"filter,omitempty"`
+}
+
 func NewBackup(rs string, pbm *pbm.PBM, node *pbm.Node) (*IBackup, error) {
 	return &IBackup{
-		pbm:  pbm,
-		node: node,
-		rs:   rs,
-		span: defaultSpan,
-		log:  node.Log,
+		pbm:      pbm,
+		node:     node,
+		rs:       rs,
+		schedule: defaultSchedule,
+		log:      node.Log,
 	}, nil
 }
 
+// SetSchedule overrides the adaptive slicing cadence (see Schedule) used by Stream. Fields left
+// at their zero value fall back to defaultSchedule rather than disabling that bound.
+func (i *IBackup) SetSchedule(s Schedule) {
+	if s.RPO <= 0 {
+		s.RPO = defaultSchedule.RPO
+	}
+	if s.MaxChunkBytes <= 0 {
+		s.MaxChunkBytes = defaultSchedule.MaxChunkBytes
+	}
+	if s.MinSpan <= 0 {
+		s.MinSpan = defaultSchedule.MinSpan
+	}
+	if s.MaxSpan <= 0 {
+		s.MaxSpan = defaultSchedule.MaxSpan
+	}
+
+	i.schedule = s
+}
+
+// SetCipher turns on client-side encryption of oplog chunks written by Stream. A nil CipherInfo
+// disables it. Like the tailing span, it's meant to be set once before Stream starts - changing
+// it on an already-running timeline would leave chunks that decrypt with different keys.
+func (i *IBackup) SetCipher(ci *CipherInfo) {
+	i.cipher = ci
+}
+
+// SetFilter turns on namespace filtering of the oplog chunks written by Stream. Patterns are
+// validated up front so a typo surfaces immediately rather than after streaming has started. As
+// with the tailing span, it's meant to be set once before Stream runs - Stream itself refuses to
+// continue a timeline under a different filter than the one its last committed chunk used.
+func (i *IBackup) SetFilter(include, exclude []string) error {
+	for _, p := range include {
+		if _, err := path.Match(p, ""); err != nil {
+			return errors.Wrapf(err, "invalid include pattern %q", p)
+		}
+	}
+	for _, p := range exclude {
+		if _, err := path.Match(p, ""); err != nil {
+			return errors.Wrapf(err, "invalid exclude pattern %q", p)
+		}
+	}
+
+	i.filter = &NSFilter{Include: include, Exclude: exclude}
+
+	return nil
+}
+
 // Catchup seeks for the last saved (backuped) TS - the starting point.  It should be run only
 // if the timeline was lost (e.g. on (re)start or another node's fail).
-// The starting point sets to the last backup's or last PITR chunk's TS whichever is more recent
-func (i *IBackup) Catchup() error {
+// The starting point sets to the last backup's or last PITR chunk's TS whichever is more recent.
+// It also reconciles any checkpoint left behind by a crashed or evicted worker: if the in-flight
+// chunk it describes is actually sitting in storage, it's either adopted (and its meta inserted)
+// or thrown away so streaming can safely resume from the checkpoint's lastTS. The checkpoint and
+// chunk meta only ever live on the primary target - see Target - so that's the only one consulted
+// here; mirrors are reconciled separately, in the background, once Stream is running.
+func (i *IBackup) Catchup(targets []Target) error {
+	to, err := primaryTarget(targets)
+	if err != nil {
+		return errors.Wrap(err, "primary target")
+	}
+
 	bcp, err := i.pbm.GetLastBackup(nil)
 	if err != nil {
 		return errors.Wrap(err, "get last backup")
@@ -65,26 +315,111 @@ func (i *IBackup) Catchup() error {
 		return errors.Wrap(err, "get last backup")
 	}
 
-	if chnk == nil {
+	if chnk != nil && chnk.EndTS.T > i.lastTS.T {
+		i.lastTS = chnk.EndTS
+	}
+
+	cp, err := i.loadCheckpoint(to)
+	if err != nil {
+		return errors.Wrap(err, "load checkpoint")
+	}
+	if cp == nil {
 		return nil
 	}
 
-	if chnk.EndTS.T > i.lastTS.T {
-		i.lastTS = chnk.EndTS
+	if cp.InflightFName != "" {
+		if err := i.reconcileInflight(to, cp, chnk); err != nil {
+			return errors.Wrap(err, "reconcile in-flight checkpoint chunk")
+		}
+	}
+
+	if cp.LastTS.T > i.lastTS.T {
+		i.lastTS = cp.LastTS
 	}
 
 	return nil
 }
 
-// Stream streaming (saving) chunks of the oplog to the given storage
-func (i *IBackup) Stream(ctx context.Context, wakeupSig <-chan struct{}, to storage.Storage, compression pbm.CompressionType) error {
+// reconcileInflight decides the fate of the chunk an interrupted worker was uploading when it
+// died. If the chunk meta was already committed (normal race with a prune that hasn't run yet),
+// or the object isn't present in storage at all, there's nothing to adopt and it's just removed.
+// Otherwise a non-empty object is adopted via PITRAddChunk as covering [InflightStartTS,
+// PlannedEndTS]; the checkpoint records no expected size, so a non-empty object is not checked for
+// truncation. An empty object is deleted, along with the checkpoint.
+func (i *IBackup) reconcileInflight(to storage.Storage, cp *checkpoint, last *pbm.PITRChunk) error {
+	if last != nil && last.FName == cp.InflightFName {
+		return i.deleteCheckpoint(to)
+	}
+
+	fi, err := to.FileStat(cp.InflightFName)
+	if errors.Is(err, storage.ErrNotExist) {
+		return i.deleteCheckpoint(to)
+	}
+	if err != nil {
+		return errors.Wrapf(err, "stat %s", cp.InflightFName)
+	}
+
+	if fi.Size <= 0 {
+		if err := to.Delete(cp.InflightFName); err != nil && !errors.Is(err, storage.ErrNotExist) {
+			return errors.Wrapf(err, "delete incomplete chunk %s", cp.InflightFName)
+		}
+		return i.deleteCheckpoint(to)
+	}
+
+	recovered := pbm.PITRChunk{
+		RS:          i.rs,
+		FName:       cp.InflightFName,
+		Compression: cp.Compression,
+		StartTS:     cp.InflightStartTS,
+		EndTS:       cp.PlannedEndTS,
+		Filter:      cp.Filter,
+	}
+	if cp.CipherAlgorithm != CipherNone {
+		recovered.CipherAlgorithm = string(cp.CipherAlgorithm)
+		recovered.CipherWrappedKey = cp.CipherWrappedKey
+		recovered.CipherNonceSize = encNonceSize
+	}
+
+	err = i.pbm.PITRAddChunk(recovered)
+	if err != nil {
+		return errors.Wrap(err, "add recovered chunk meta")
+	}
+
+	i.lastTS = cp.PlannedEndTS
+
+	return i.deleteCheckpoint(to)
+}
+
+// Stream streaming (saving) chunks of the oplog to the given targets. targets[0] is the primary -
+// see Target - and is what the checkpoint, chunk meta and namespace manifest are read from and
+// written to; any further targets are mirrors uploaded to concurrently with the primary on a
+// best-effort basis and kept in sync by a background reconciler.
+func (i *IBackup) Stream(ctx context.Context, wakeupSig <-chan struct{}, targets []Target, compression pbm.CompressionType) error {
 	if i.lastTS.T == 0 {
 		return errors.New("no starting point defined")
 	}
-	i.log.Info(pbm.CmdPITR, "", "streaming started from %v / %v", time.Unix(int64(i.lastTS.T), 0).UTC(), i.lastTS.T)
 
-	tk := time.NewTicker(i.span)
-	defer tk.Stop()
+	to, err := primaryTarget(targets)
+	if err != nil {
+		return errors.Wrap(err, "primary target")
+	}
+
+	filterFP := ""
+	if i.filter != nil {
+		filterFP = i.filter.Fingerprint()
+	}
+	lastChunk, err := i.pbm.PITRLastChunkMeta(i.rs)
+	if err != nil {
+		return errors.Wrap(err, "get last chunk meta")
+	}
+	if lastChunk != nil && lastChunk.Filter != filterFP {
+		return errors.New("namespace filter changed since the last streamed chunk: " +
+			"Catchup to a new starting point before streaming with a different filter")
+	}
+
+	i.log.Info(pbm.CmdPITR, "", "streaming started from %v / %v", time.Unix(int64(i.lastTS.T), 0).UTC(), i.lastTS.T)
+	i.log.Info(pbm.CmdPITR, "", "slicing schedule: rpo=%v maxChunkBytes=%d minSpan=%v maxSpan=%v",
+		i.schedule.RPO, i.schedule.MaxChunkBytes, i.schedule.MinSpan, i.schedule.MaxSpan)
 
 	llock := &pbm.LockHeader{Replset: i.rs}
 	nodeInfo, err := i.node.GetIsMaster()
@@ -94,20 +429,31 @@ func (i *IBackup) Stream(ctx context.Context, wakeupSig <-chan struct{}, to stor
 
 	lastSlice := false
 
+	pctx, pcancel := context.WithCancel(ctx)
+	defer pcancel()
+	go i.runCheckpointPruner(pctx, to)
+
+	var mirrors []storage.Storage
+	for _, t := range targets[1:] {
+		mirrors = append(mirrors, t.Storage)
+	}
+	go i.runMirrorReconciler(pctx, to, mirrors)
+
 	var sliceTo primitive.Timestamp
 	oplog := backup.NewOplog(i.node)
+	if i.filter != nil {
+		oplog.SetFilter(i.filter.Include, i.filter.Exclude)
+	}
 	for {
-		// waiting for a trigger
-		select {
-		// wrapping up at the current point-in-time
-		case <-ctx.Done():
-			i.log.Info(pbm.CmdPITR, "", "got done signal, stopping")
-			// lastSlice = true
-			return nil
-		// on wakeup or tick whatever comes first do the job
-		case <-wakeupSig:
-			i.log.Info(pbm.CmdPITR, "", "got wake_up signal")
-		case <-tk.C:
+		// waiting for a trigger: either a wakeup, or the adaptive scheduler deciding
+		// it's time to cut the next slice (see Schedule)
+		err := i.awaitNextSlice(ctx, wakeupSig, oplog)
+		if err != nil {
+			if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+				i.log.Info(pbm.CmdPITR, "", "got done signal, stopping")
+				return nil
+			}
+			return errors.Wrap(err, "await next slice")
 		}
 
 		// check if the node is still any good to make backups
@@ -162,10 +508,56 @@ func (i *IBackup) Stream(ctx context.Context, wakeupSig <-chan struct{}, to stor
 
 		oplog.SetTailingSpan(i.lastTS, sliceTo)
 		fname := i.chunkPath(i.lastTS.T, sliceTo.T, compression)
-		_, err = backup.Upload(ctx, oplog, to, compression, fname)
+
+		cp := checkpoint{
+			LastTS:          i.lastTS,
+			InflightFName:   fname,
+			InflightStartTS: i.lastTS,
+			PlannedEndTS:    sliceTo,
+			Compression:     compression,
+		}
+		if i.cipher != nil {
+			cp.CipherAlgorithm = i.cipher.Algorithm
+			cp.CipherKDF = i.cipher.KDF
+			cp.CipherWrappedKey = i.cipher.WrappedKey
+		}
+		cp.Filter = filterFP
+		if err := i.saveCheckpoint(to, cp); err != nil {
+			return errors.Wrap(err, "save checkpoint")
+		}
+
+		tailer := &checkpointTailer{r: oplog, oplog: oplog, flush: func(ts primitive.Timestamp) error {
+			cp := cp
+			cp.LastTS = ts
+			return i.saveCheckpoint(to, cp)
+		}}
+
+		// Compress before encrypting: AEAD ciphertext is indistinguishable from random data, so
+		// a compressor fed encrypted bytes achieves essentially nothing while still paying the
+		// CPU cost. With the cipher on, backup.Upload is handed the already-compressed stream
+		// and told not to compress it again (pbm.CompressionTypeNone); the original compression
+		// is still what's recorded in the chunk's filename and meta.
+		var r io.Reader = tailer
+		uploadCompression := compression
+		if i.cipher != nil {
+			cr, err := compressReader(tailer, compression)
+			if err != nil {
+				return errors.Wrap(err, "set up chunk compression")
+			}
+			er, err := newCipherReader(cr, i.cipher)
+			if err != nil {
+				return errors.Wrap(err, "set up chunk encryption")
+			}
+			r = er
+			uploadCompression = pbm.CompressionTypeNone
+		}
+
+		uploadStart := time.Now()
+		sz, err := i.uploadToTargets(ctx, r, targets, fname, uploadCompression)
 		if err != nil {
 			return errors.Wrapf(err, "unable to upload chunk %v.%v", i.lastTS.T, sliceTo.T)
 		}
+		metrics.addSlice(sz, time.Since(uploadStart))
 
 		meta := pbm.PITRChunk{
 			RS:          i.rs,
@@ -173,12 +565,28 @@ func (i *IBackup) Stream(ctx context.Context, wakeupSig <-chan struct{}, to stor
 			Compression: compression,
 			StartTS:     i.lastTS,
 			EndTS:       sliceTo,
+			Filter:      filterFP,
+		}
+		if i.cipher != nil {
+			meta.CipherAlgorithm = string(i.cipher.Algorithm)
+			meta.CipherWrappedKey = i.cipher.WrappedKey
+			meta.CipherNonceSize = encNonceSize
 		}
 		err = i.pbm.PITRAddChunk(meta)
 		if err != nil {
 			return errors.Wrapf(err, "unable to save chunk meta %v", meta)
 		}
 
+		if i.filter != nil {
+			if err := i.writeManifest(to, fname, oplog.MatchedNamespaces()); err != nil {
+				i.log.Warning(pbm.CmdPITR, "", "write namespace manifest for %s: %v", fname, err)
+			}
+		}
+
+		if err := i.deleteCheckpoint(to); err != nil {
+			i.log.Warning(pbm.CmdPITR, "", "delete checkpoint for committed chunk %s: %v", fname, err)
+		}
+
 		if lastSlice {
 			i.log.Info(pbm.CmdPITR, "", "pausing/stopping with last_ts %v", time.Unix(int64(sliceTo.T), 0).UTC())
 			return nil
@@ -188,6 +596,94 @@ func (i *IBackup) Stream(ctx context.Context, wakeupSig <-chan struct{}, to stor
 	}
 }
 
+// oplogClock is the subset of *backup.Oplog awaitNextSlice needs to make its cutting decision,
+// narrowed to an interface so the adaptive scheduler's cadence logic can be unit-tested without a
+// live oplog to tail.
+type oplogClock interface {
+	LastWrite() (primitive.Timestamp, error)
+	EstimateSize(from, to primitive.Timestamp) (int64, error)
+}
+
+// awaitNextSlice blocks until it's time to cut the next slice, per i.schedule, or the caller asks
+// to stop. It returns ctx.Err() on ctx cancellation, nil once a slice should be cut (either an
+// explicit wakeupSig, or the scheduler's own decision), and a non-nil error only on an actual
+// failure talking to the node.
+func (i *IBackup) awaitNextSlice(ctx context.Context, wakeupSig <-chan struct{}, oplog oplogClock) error {
+	sched := i.schedule
+
+	poll := schedulePollInterval
+	if sched.MinSpan < poll {
+		poll = sched.MinSpan
+	}
+	tk := time.NewTicker(poll)
+	defer tk.Stop()
+
+	started := time.Now()
+	var rateEWMA float64
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-wakeupSig:
+			i.log.Info(pbm.CmdPITR, "", "got wake_up signal")
+			return nil
+		case <-tk.C:
+		}
+
+		elapsed := time.Since(started)
+		if elapsed < sched.MinSpan {
+			continue
+		}
+		if elapsed >= sched.MaxSpan {
+			return nil
+		}
+
+		// The RPO deadline is measured against when the in-flight slice started (elapsed), not
+		// against how recently the oplog itself was written to (oplog.LastWrite): on an idle
+		// cluster the latter never advances, so gauging the deadline off it would either force a
+		// cut immediately (if the oplog had already been idle before this slice started) or,
+		// depending on how stale it is, never fire at all - neither tracks the slice's own age.
+		metrics.setLag(elapsed)
+		if elapsed >= sched.RPO {
+			return nil
+		}
+
+		lw, err := oplog.LastWrite()
+		if err != nil {
+			return errors.Wrap(err, "define last write timestamp")
+		}
+
+		sz, err := oplog.EstimateSize(i.lastTS, lw)
+		if err != nil {
+			return errors.Wrap(err, "estimate slice size")
+		}
+		if sz >= sched.MaxChunkBytes {
+			return nil
+		}
+
+		es := elapsed.Seconds()
+		if es <= 0 || sz <= 0 {
+			continue
+		}
+
+		rate := float64(sz) / es
+		if rateEWMA == 0 {
+			rateEWMA = rate
+		} else {
+			rateEWMA = ewmaAlpha*rate + (1-ewmaAlpha)*rateEWMA
+		}
+		if rateEWMA <= 0 {
+			continue
+		}
+
+		timeToMax := time.Duration(float64(sched.MaxChunkBytes-sz) / rateEWMA * float64(time.Second))
+		if elapsed+timeToMax >= sched.RPO {
+			return nil
+		}
+	}
+}
+
 func (i *IBackup) getOpLock(l *pbm.LockHeader) (ld pbm.LockData, err error) {
 	tk := time.NewTicker(time.Second)
 	defer tk.Stop()
@@ -242,10 +738,49 @@ func (i *IBackup) chunkPath(first, last uint32, c pbm.CompressionType) string {
 	name.WriteString(lt.Format("20060102150405"))
 	name.WriteString(".oplog")
 	name.WriteString(csuffix(c))
+	if i.cipher != nil {
+		name.WriteString(".enc")
+	}
 
 	return name.String()
 }
 
+// compressReader returns a reader of r compressed with c, computed on a background goroutine
+// through the matching compress.Writer and handed back over an io.Pipe - the same
+// don't-buffer-the-whole-slice pattern uploadToTargets uses. c == pbm.CompressionTypeNone is a
+// no-op passthrough. Only used when the chunk is also being encrypted (see Stream): compression
+// has to happen before newCipherReader, never after, since AEAD output has no structure left for
+// a compressor to exploit.
+func compressReader(r io.Reader, c pbm.CompressionType) (io.Reader, error) {
+	var newWriter func(io.Writer) io.WriteCloser
+	switch c {
+	case pbm.CompressionTypeGZIP:
+		newWriter = func(w io.Writer) io.WriteCloser { return gzip.NewWriter(w) }
+	case pbm.CompressionTypePGZIP:
+		newWriter = func(w io.Writer) io.WriteCloser { return pgzip.NewWriter(w) }
+	case pbm.CompressionTypeLZ4:
+		newWriter = func(w io.Writer) io.WriteCloser { return lz4.NewWriter(w) }
+	case pbm.CompressionTypeSNAPPY:
+		newWriter = func(w io.Writer) io.WriteCloser { return snappy.NewBufferedWriter(w) }
+	case pbm.CompressionTypeS2:
+		newWriter = func(w io.Writer) io.WriteCloser { return s2.NewWriter(w) }
+	default:
+		return r, nil
+	}
+
+	pr, pw := io.Pipe()
+	go func() {
+		cw := newWriter(pw)
+		_, err := io.Copy(cw, r)
+		if cerr := cw.Close(); err == nil {
+			err = cerr
+		}
+		pw.CloseWithError(err)
+	}()
+
+	return pr, nil
+}
+
 func csuffix(c pbm.CompressionType) string {
 	switch c {
 	case pbm.CompressionTypeGZIP, pbm.CompressionTypePGZIP:
@@ -258,3 +793,433 @@ func csuffix(c pbm.CompressionType) string {
 		return ""
 	}
 }
+
+// nsManifest is the sidecar recorded next to a filtered chunk, listing the namespaces that
+// actually ended up in it. It's informational - PITRChunk.Filter, not the manifest, is what
+// restore checks for a filter mismatch - but it lets an operator or tool inspect a chunk's
+// contents without decompressing (and, if encrypted, decrypting) it first.
+type nsManifest struct {
+	Namespaces []string `json:"namespaces"`
+}
+
+func (i *IBackup) writeManifest(to storage.Storage, chunkFName string, namespaces []string) error {
+	b, err := json.Marshal(nsManifest{Namespaces: namespaces})
+	if err != nil {
+		return errors.Wrap(err, "marshal manifest")
+	}
+
+	return errors.Wrap(to.Save(chunkFName+".manifest.json", bytes.NewReader(b), int64(len(b))), "write manifest")
+}
+
+func (i *IBackup) checkpointPath() string {
+	return fsPrefix + "/" + i.rs + "/" + checkpointFile
+}
+
+func (i *IBackup) saveCheckpoint(to storage.Storage, cp checkpoint) error {
+	b, err := json.Marshal(cp)
+	if err != nil {
+		return errors.Wrap(err, "marshal checkpoint")
+	}
+
+	return errors.Wrap(to.Save(i.checkpointPath(), strings.NewReader(string(b)), int64(len(b))), "write checkpoint")
+}
+
+func (i *IBackup) loadCheckpoint(to storage.Storage) (*checkpoint, error) {
+	r, err := to.SourceReader(i.checkpointPath())
+	if errors.Is(err, storage.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "open checkpoint")
+	}
+	defer r.Close()
+
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, errors.Wrap(err, "read checkpoint")
+	}
+
+	cp := new(checkpoint)
+	if err := json.Unmarshal(b, cp); err != nil {
+		return nil, errors.Wrap(err, "unmarshal checkpoint")
+	}
+
+	return cp, nil
+}
+
+func (i *IBackup) deleteCheckpoint(to storage.Storage) error {
+	err := to.Delete(i.checkpointPath())
+	if err != nil && !errors.Is(err, storage.ErrNotExist) {
+		return errors.Wrap(err, "delete checkpoint")
+	}
+
+	return nil
+}
+
+// runCheckpointPruner periodically removes checkpoints whose in-flight chunk has already been
+// committed to PITRChunk meta (e.g. left behind by a restart that raced the commit). It runs for
+// the lifetime of the Stream call and is best-effort: a failed prune is logged and retried on the
+// next tick rather than failing the whole PITR worker.
+func (i *IBackup) runCheckpointPruner(ctx context.Context, to storage.Storage) {
+	tk := time.NewTicker(defaultSpan)
+	defer tk.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-tk.C:
+		}
+
+		cp, err := i.loadCheckpoint(to)
+		if err != nil {
+			i.log.Warning(pbm.CmdPITR, "", "checkpoint pruner: load checkpoint: %v", err)
+			continue
+		}
+		if cp == nil || cp.InflightFName == "" {
+			continue
+		}
+
+		last, err := i.pbm.PITRLastChunkMeta(i.rs)
+		if err != nil {
+			i.log.Warning(pbm.CmdPITR, "", "checkpoint pruner: get last chunk meta: %v", err)
+			continue
+		}
+		if last == nil || last.FName != cp.InflightFName {
+			continue
+		}
+
+		if err := i.deleteCheckpoint(to); err != nil {
+			i.log.Warning(pbm.CmdPITR, "", "checkpoint pruner: delete checkpoint: %v", err)
+		}
+	}
+}
+
+// mirrorQueueDepth bounds how many pending writes a best-effort mirror's feed will buffer before
+// it's judged unable to keep up and dropped for the slice - large enough to absorb a brief stall,
+// small enough that a mirror that's actually stuck doesn't accumulate the whole slice in memory.
+const mirrorQueueDepth = 64
+
+// mirrorFeed decouples a non-required (mirror) target's upload pace from the shared read of a
+// slice: writes are queued and drained into pw by an independent goroutine, so a mirror that's
+// slow - or stuck outright - never blocks whoever is feeding the other targets. If the queue fills,
+// draining stops, pw is closed with an error, and further writes are silently dropped instead of
+// blocking.
+type mirrorFeed struct {
+	pw      *io.PipeWriter
+	queue   chan []byte
+	stopped int32
+}
+
+func newMirrorFeed(pw *io.PipeWriter) *mirrorFeed {
+	f := &mirrorFeed{pw: pw, queue: make(chan []byte, mirrorQueueDepth)}
+	go func() {
+		for b := range f.queue {
+			if _, err := f.pw.Write(b); err != nil {
+				atomic.StoreInt32(&f.stopped, 1)
+				return
+			}
+		}
+		// The queue was closed by done(), not by a stall - every write was flushed. Close pw
+		// with a nil error so the reader on the other end (backup.Upload) sees a clean EOF
+		// instead of blocking forever waiting for one.
+		f.pw.Close()
+	}()
+
+	return f
+}
+
+// write queues p for the mirror if it's still keeping up, drops it (and stops the mirror for the
+// rest of the slice) if the queue is already full, and no-ops if the mirror has already been
+// stopped. It never blocks.
+func (f *mirrorFeed) write(p []byte) {
+	if atomic.LoadInt32(&f.stopped) != 0 {
+		return
+	}
+
+	b := append([]byte(nil), p...)
+	select {
+	case f.queue <- b:
+	default:
+		// CAS, not a plain store: whoever wins the race to stop the feed is the one that closes
+		// queue, so it's closed exactly once regardless of how many writers hit a full queue (or
+		// race with done()) at the same time.
+		if atomic.CompareAndSwapInt32(&f.stopped, 0, 1) {
+			f.pw.CloseWithError(errors.New("mirror fell behind the primary and was dropped for this slice"))
+			close(f.queue)
+		}
+	}
+}
+
+// done signals that no more writes are coming, letting the drain goroutine close pw once it has
+// flushed whatever's still queued.
+func (f *mirrorFeed) done() {
+	if atomic.CompareAndSwapInt32(&f.stopped, 0, 1) {
+		close(f.queue)
+	}
+}
+
+// uploadToTargets tees a slice to every target and returns the size backup.Upload reported for the
+// primary. The primary and any other Required target are written synchronously off the shared read
+// of r - a stall or failure there legitimately aborts the whole slice, same as a single-target
+// upload failing always has, so there's nothing to isolate. Each non-required (mirror) target
+// instead gets its own mirrorFeed: an independently-drained, bounded queue, so a mirror that can't
+// keep up only takes itself out (for the background mirror reconciler to back-fill later) instead
+// of - as a shared io.MultiWriter feeding a single io.Copy would - stalling or aborting every other
+// target, including the primary, right along with it.
+func (i *IBackup) uploadToTargets(ctx context.Context, r io.Reader, targets []Target, fname string, compression pbm.CompressionType) (int64, error) {
+	prs := make([]*io.PipeReader, len(targets))
+	pws := make([]*io.PipeWriter, len(targets))
+	feeds := make([]*mirrorFeed, len(targets))
+	for j, t := range targets {
+		prs[j], pws[j] = io.Pipe()
+		if !t.Required {
+			feeds[j] = newMirrorFeed(pws[j])
+		}
+	}
+
+	go func() {
+		reqDone := make([]bool, len(targets))
+		buf := make([]byte, 32*1024)
+		var readErr error
+
+		for {
+			n, err := r.Read(buf)
+			if n > 0 {
+				for j, t := range targets {
+					if !t.Required {
+						feeds[j].write(buf[:n])
+						continue
+					}
+					if reqDone[j] {
+						continue
+					}
+					if _, werr := pws[j].Write(buf[:n]); werr != nil {
+						reqDone[j] = true
+					}
+				}
+			}
+			if err != nil {
+				if err != io.EOF {
+					readErr = err
+				}
+				break
+			}
+		}
+
+		for j, t := range targets {
+			if !t.Required {
+				feeds[j].done()
+			} else if !reqDone[j] {
+				pws[j].CloseWithError(readErr)
+			}
+		}
+	}()
+
+	sizes := make([]int64, len(targets))
+	errs := make([]error, len(targets))
+	var wg sync.WaitGroup
+	wg.Add(len(targets))
+	for j, t := range targets {
+		go func(j int, t Target) {
+			defer wg.Done()
+			sz, err := backup.Upload(ctx, prs[j], t.Storage, compression, fname)
+			prs[j].CloseWithError(err)
+			sizes[j] = sz
+			errs[j] = err
+		}(j, t)
+	}
+	wg.Wait()
+
+	for j, t := range targets {
+		if errs[j] == nil {
+			continue
+		}
+		if t.Required {
+			return 0, errors.Wrapf(errs[j], "upload to required target #%d", j)
+		}
+		i.log.Warning(pbm.CmdPITR, "", "upload %s to best-effort target #%d: %v, "+
+			"mirror reconciler will back-fill it", fname, j, errs[j])
+	}
+
+	return sizes[0], nil
+}
+
+// runMirrorReconciler periodically brings best-effort mirrors up to date with the primary,
+// covering both a slice that failed to upload to a mirror and a mirror an operator only just
+// pointed at an existing timeline. It runs for the lifetime of the Stream call, same as the
+// checkpoint pruner, and is equally best-effort: a failed pass is logged and retried on the next
+// tick rather than failing the whole PITR worker.
+func (i *IBackup) runMirrorReconciler(ctx context.Context, primary storage.Storage, mirrors []storage.Storage) {
+	if len(mirrors) == 0 {
+		return
+	}
+
+	tk := time.NewTicker(defaultSpan)
+	defer tk.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-tk.C:
+		}
+
+		for _, m := range mirrors {
+			if err := i.Reconcile(primary, m); err != nil {
+				i.log.Warning(pbm.CmdPITR, "", "mirror reconciler: %v", err)
+			}
+		}
+	}
+}
+
+// Reconcile copies every chunk this replset has recorded in PITRChunk meta but that's missing
+// from to, reading it back from from. PITRChunk meta, not a storage listing, is the source of
+// truth for what should exist - the same principle Catchup's checkpoint reconciliation uses -
+// which lets an operator add a mirror after the fact and have it back-fill the whole timeline by
+// simply calling this once, rather than needing a dedicated bulk-copy tool.
+func (i *IBackup) Reconcile(from, to storage.Storage) error {
+	chunks, err := i.pbm.PITRGetChunksSlice(i.rs, time.Time{}, time.Now())
+	if err != nil {
+		return errors.Wrap(err, "get chunks")
+	}
+
+	for _, c := range chunks {
+		if err := i.copyChunk(from, to, c.FName); err != nil {
+			return errors.Wrapf(err, "copy chunk %s", c.FName)
+		}
+	}
+
+	return nil
+}
+
+func (i *IBackup) copyChunk(from, to storage.Storage, fname string) error {
+	_, err := to.FileStat(fname)
+	if err == nil {
+		return nil
+	}
+	if !errors.Is(err, storage.ErrNotExist) {
+		return errors.Wrapf(err, "stat %s on mirror", fname)
+	}
+
+	fi, err := from.FileStat(fname)
+	if err != nil {
+		return errors.Wrapf(err, "stat %s on primary", fname)
+	}
+
+	r, err := from.SourceReader(fname)
+	if err != nil {
+		return errors.Wrapf(err, "open %s on primary", fname)
+	}
+	defer r.Close()
+
+	return errors.Wrap(to.Save(fname, r, fi.Size), "save")
+}
+
+// checkpointTailer wraps the oplog tailer being read by backup.Upload and, every
+// checkpointFlushBytes, persists the tailer's own read progress (oplog.LastRead) via flush - not
+// oplog.LastWrite, which is the timestamp of the newest entry anywhere in the oplog and keeps
+// advancing while the slice is uploaded, regardless of how much of it has actually been read. A
+// crash right after a flush of LastWrite could resume from a point further along than what was
+// really persisted, silently skipping the gap in between; LastRead never gets ahead of what this
+// tailer has actually emitted. This bounds how much of a long slice would need to be re-streamed
+// after a crash to one flush interval instead of the whole slice.
+type checkpointTailer struct {
+	r         io.Reader
+	oplog     *backup.Oplog
+	flush     func(ts primitive.Timestamp) error
+	unflushed int64
+}
+
+func (t *checkpointTailer) Read(p []byte) (int, error) {
+	n, err := t.r.Read(p)
+	if n > 0 {
+		t.unflushed += int64(n)
+		if t.unflushed >= checkpointFlushBytes {
+			t.unflushed = 0
+			if fErr := t.flush(t.oplog.LastRead()); fErr != nil {
+				return n, fErr
+			}
+		}
+	}
+
+	return n, err
+}
+
+// cipherReader wraps a plaintext oplog reader and emits an encrypted, self-framed stream: a
+// 12-byte base nonce header followed by a sequence of independently-authenticated AES-256-GCM
+// frames, each sealing up to encFrameSize plaintext bytes. Framing lets restore authenticate and
+// decrypt the chunk incrementally instead of buffering the whole slice in memory on either end.
+type cipherReader struct {
+	src   io.Reader
+	aead  cipher.AEAD
+	nonce []byte
+	seq   uint64
+	out   bytes.Buffer
+	eof   bool
+}
+
+func newCipherReader(src io.Reader, ci *CipherInfo) (*cipherReader, error) {
+	if len(ci.Key) != 32 {
+		return nil, errors.Errorf("%s requires a 32-byte key, got %d bytes", CipherAES256GCM, len(ci.Key))
+	}
+
+	block, err := aes.NewCipher(ci.Key)
+	if err != nil {
+		return nil, errors.Wrap(err, "new AES cipher")
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, errors.Wrap(err, "new GCM AEAD")
+	}
+
+	nonce := make([]byte, encNonceSize)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, errors.Wrap(err, "generate nonce")
+	}
+
+	cr := &cipherReader{src: src, aead: aead, nonce: nonce}
+	cr.out.Write(nonce)
+
+	return cr, nil
+}
+
+// frameNonce derives a unique nonce for frame c.seq by XOR-ing the frame counter into the low
+// 8 bytes of the random base nonce, so no two frames of a chunk ever reuse a nonce under the
+// same key.
+func (c *cipherReader) frameNonce() []byte {
+	n := make([]byte, encNonceSize)
+	copy(n, c.nonce)
+	var seq [8]byte
+	binary.BigEndian.PutUint64(seq[:], c.seq)
+	for j, b := range seq {
+		n[encNonceSize-8+j] ^= b
+	}
+
+	return n
+}
+
+func (c *cipherReader) Read(p []byte) (int, error) {
+	for c.out.Len() == 0 && !c.eof {
+		buf := make([]byte, encFrameSize)
+		n, err := io.ReadFull(c.src, buf)
+		if n > 0 {
+			frame := c.aead.Seal(nil, c.frameNonce(), buf[:n], nil)
+			c.seq++
+
+			lenBuf := make([]byte, 4)
+			binary.BigEndian.PutUint32(lenBuf, uint32(len(frame)))
+			c.out.Write(lenBuf)
+			c.out.Write(frame)
+		}
+
+		switch {
+		case err == io.EOF, err == io.ErrUnexpectedEOF:
+			c.eof = true
+		case err != nil:
+			return 0, errors.Wrap(err, "read plaintext frame")
+		}
+	}
+
+	return c.out.Read(p)
+}
@@ -0,0 +1,93 @@
+package pitr
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// fakeOplogClock is a fixed-response oplogClock, letting awaitNextSlice's cadence logic be tested
+// without a live oplog to tail.
+type fakeOplogClock struct {
+	lastWrite primitive.Timestamp
+	size      int64
+}
+
+func (f *fakeOplogClock) LastWrite() (primitive.Timestamp, error) { return f.lastWrite, nil }
+func (f *fakeOplogClock) EstimateSize(_, _ primitive.Timestamp) (int64, error) {
+	return f.size, nil
+}
+
+func TestAwaitNextSliceCutsOnRPOEvenWithStaleOplogLastWrite(t *testing.T) {
+	i := &IBackup{
+		schedule: Schedule{
+			RPO:           40 * time.Millisecond,
+			MaxChunkBytes: 1 << 30,
+			MinSpan:       5 * time.Millisecond,
+			MaxSpan:       time.Hour,
+		},
+		lastTS: primitive.Timestamp{T: uint32(time.Now().Unix())},
+	}
+
+	// The oplog's last write is an hour stale - as it would be on a genuinely idle cluster. The
+	// old, buggy calculation derived the RPO deadline from this timestamp and would have cut
+	// (almost) immediately; the fix must instead wait out a full RPO from when this slice
+	// actually started.
+	stale := primitive.Timestamp{T: uint32(time.Now().Add(-time.Hour).Unix())}
+	fake := &fakeOplogClock{lastWrite: stale}
+
+	start := time.Now()
+	err := i.awaitNextSlice(context.Background(), nil, fake)
+	elapsed := time.Since(start)
+
+	if err != nil {
+		t.Fatalf("awaitNextSlice: %v", err)
+	}
+	if elapsed < i.schedule.RPO {
+		t.Fatalf("cut after %v, want at least the configured RPO (%v) despite a stale oplog last-write",
+			elapsed, i.schedule.RPO)
+	}
+}
+
+func TestAwaitNextSliceCutsOnMaxChunkBytes(t *testing.T) {
+	i := &IBackup{
+		schedule: Schedule{
+			RPO:           time.Hour,
+			MaxChunkBytes: 100,
+			MinSpan:       5 * time.Millisecond,
+			MaxSpan:       time.Hour,
+		},
+		lastTS: primitive.Timestamp{T: uint32(time.Now().Unix())},
+	}
+	fake := &fakeOplogClock{lastWrite: i.lastTS, size: 1000}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	if err := i.awaitNextSlice(ctx, nil, fake); err != nil {
+		t.Fatalf("awaitNextSlice: %v", err)
+	}
+}
+
+func TestAwaitNextSliceRespectsMaxSpan(t *testing.T) {
+	i := &IBackup{
+		schedule: Schedule{
+			RPO:           time.Hour,
+			MaxChunkBytes: 1 << 30,
+			MinSpan:       5 * time.Millisecond,
+			MaxSpan:       20 * time.Millisecond,
+		},
+		lastTS: primitive.Timestamp{T: uint32(time.Now().Unix())},
+	}
+	fake := &fakeOplogClock{lastWrite: i.lastTS}
+
+	start := time.Now()
+	if err := i.awaitNextSlice(context.Background(), nil, fake); err != nil {
+		t.Fatalf("awaitNextSlice: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < i.schedule.MaxSpan {
+		t.Fatalf("cut after %v, want at least MaxSpan (%v)", elapsed, i.schedule.MaxSpan)
+	}
+}
@@ -0,0 +1,31 @@
+package pbm
+
+import "go.mongodb.org/mongo-driver/bson/primitive"
+
+// PITRChunk is the metadata record PITRAddChunk persists for one streamed oplog slice: where it
+// lives (FName), the span it covers ([StartTS, EndTS]) and how to read it back (Compression, and,
+// if the chunk is encrypted, the Cipher* fields below).
+type PITRChunk struct {
+	RS          string              `bson:"rs" json:"rs"`
+	FName       string              `bson:"fname" json:"fname"`
+	Compression CompressionType     `bson:"compression" json:"compression"`
+	StartTS     primitive.Timestamp `bson:"start_ts" json:"start_ts"`
+	EndTS       primitive.Timestamp `bson:"end_ts" json:"end_ts"`
+
+	// CipherAlgorithm identifies the AEAD the chunk was encrypted with (see
+	// pitr.CipherAlgorithm); empty if the chunk isn't encrypted.
+	CipherAlgorithm string `bson:"cipher_algorithm,omitempty" json:"cipher_algorithm,omitempty"`
+	// CipherWrappedKey is the wrapped (KDF-specific) form of the data-encryption key used for
+	// this chunk, as recorded by pitr.CipherInfo.WrappedKey - restore uses it to get the key
+	// back rather than needing the raw key stored anywhere.
+	CipherWrappedKey []byte `bson:"cipher_wrapped_key,omitempty" json:"cipher_wrapped_key,omitempty"`
+	// CipherNonceSize is the size, in bytes, of the base nonce framed at the start of the
+	// chunk's ciphertext.
+	CipherNonceSize int `bson:"cipher_nonce_size,omitempty" json:"cipher_nonce_size,omitempty"`
+
+	// Filter is the fingerprint (see pitr.NSFilter.Fingerprint) of the namespace filter active
+	// when this chunk was streamed, empty if the timeline isn't filtered. Stream refuses to
+	// continue a timeline whose last chunk carries a different fingerprint, so a restore never
+	// silently mixes differently-filtered chunks.
+	Filter string `bson:"filter,omitempty" json:"filter,omitempty"`
+}